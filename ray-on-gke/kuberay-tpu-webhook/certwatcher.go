@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog"
+)
+
+// CertWatcher serves the latest TLS keypair loaded from disk, reloading it whenever
+// cert-manager (or any other rotator) rewrites the files, so a rotated admission
+// webhook cert is picked up without a pod restart.
+type CertWatcher struct {
+	certFile, keyFile string
+	log               zerolog.Logger
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func NewCertWatcher(certFile, keyFile string, log zerolog.Logger) (*CertWatcher, error) {
+	w := &CertWatcher{certFile: certFile, keyFile: keyFile, log: log}
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *CertWatcher) reload() error {
+	cert, err := tls.LoadX509KeyPair(w.certFile, w.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS keypair: %w", err)
+	}
+
+	w.mu.Lock()
+	w.cert = &cert
+	w.mu.Unlock()
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (w *CertWatcher) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.cert, nil
+}
+
+// Watch reloads the keypair whenever certFile or keyFile change on disk. Runs until
+// ctx is cancelled.
+func (w *CertWatcher) Watch(ctx context.Context) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		w.log.Error().Err(err).Msg("Failed to start TLS cert watcher")
+		return
+	}
+	defer watcher.Close()
+
+	// secret volume mounts update by atomically swapping a symlinked directory, which
+	// doesn't emit events on the cert/key files' own inodes
+	watchedDirs := map[string]struct{}{
+		filepath.Dir(w.certFile): {},
+		filepath.Dir(w.keyFile):  {},
+	}
+	for dir := range watchedDirs {
+		if err := watcher.Add(dir); err != nil {
+			w.log.Error().Err(err).Str("dir", dir).Msg("Failed to watch TLS cert directory")
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			// don't filter by event name or op: a Secret volume rotation swaps the whole
+			// "..data" symlink target, which surfaces as an event on that symlink or the
+			// directory itself, never on tls.crt/tls.key directly
+			if err := w.reload(); err != nil {
+				w.log.Error().Err(err).Msg("Failed to reload TLS keypair")
+				continue
+			}
+			w.log.Info().Msg("Reloaded TLS keypair")
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			w.log.Error().Err(err).Msg("TLS cert watcher error")
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	rayclient "github.com/ray-project/kuberay/ray-operator/pkg/client/clientset/versioned"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// label set by the Ray operator identifying a worker pod's WorkerGroupSpec
+const rayGroupNameLabel = "ray.io/group-name"
+
+// headlessServiceName returns the name of the headless Service that groups a
+// RayCluster's multi-host TPU worker pods for DNS-based peer discovery
+func headlessServiceName(rayClusterName string) string {
+	return rayClusterName + "-headless"
+}
+
+// replicaWorkerHostname returns the DNS hostname (without the Service subdomain) of a
+// single TPU worker pod within a multi-host replica
+func replicaWorkerHostname(rayClusterName, groupName string, replicaIndex, hostIndex int) string {
+	return fmt.Sprintf("%s-%s-%d-%d", rayClusterName, groupName, replicaIndex, hostIndex)
+}
+
+// workerHostnames returns the fully-qualified hostnames of every host in a single
+// replica of a multi-host TPU worker group, in host-index order
+func workerHostnames(rayClusterName, groupName string, replicaIndex, numOfHosts int) []string {
+	subdomain := headlessServiceName(rayClusterName)
+	hostnames := make([]string, numOfHosts)
+	for h := 0; h < numOfHosts; h++ {
+		hostnames[h] = fmt.Sprintf("%s.%s", replicaWorkerHostname(rayClusterName, groupName, replicaIndex, h), subdomain)
+	}
+	return hostnames
+}
+
+// ensureHeadlessService creates the governing headless Service for a RayCluster's TPU
+// worker pods if it doesn't already exist, so the DNS names in TPU_WORKER_HOSTNAMES
+// resolve. Safe to call repeatedly.
+func ensureHeadlessService(clientset kubernetes.Interface, namespace, rayClusterName string) error {
+	name := headlessServiceName(rayClusterName)
+	_, err := clientset.CoreV1().Services(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Selector: map[string]string{
+				rayClusterNameLabel: rayClusterName,
+			},
+		},
+	}
+	_, err = clientset.CoreV1().Services(namespace).Create(context.Background(), svc, metav1.CreateOptions{})
+	return err
+}
+
+// annotations controlling injection on a per-RayCluster basis, à la the Istio sidecar
+// injector's sidecar.istio.io/inject annotation
+const (
+	tpuInjectAnnotation           = "ray.io/tpu-inject"
+	tpuTopologyOverrideAnnotation = "ray.io/tpu-topology-override"
+)
+
+// workerGroupConfig is the per-RayCluster, per-worker-group configuration that governs
+// how mutatePod computes and injects TPU context for a given pod
+type workerGroupConfig struct {
+	numOfHosts       int
+	injectionEnabled bool
+	topologyOverride string
+}
+
+// getWorkerGroupConfig reads the RayCluster owning groupName to determine how many
+// hosts its replicas have and whether/how TPU injection has been customized via
+// annotations
+func getWorkerGroupConfig(rayClient rayclient.Interface, namespace, rayClusterName, groupName string) (*workerGroupConfig, error) {
+	rayCluster, err := rayClient.RayV1().RayClusters(namespace).Get(context.Background(), rayClusterName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &workerGroupConfig{
+		numOfHosts:       1,
+		injectionEnabled: rayCluster.Annotations[tpuInjectAnnotation] != "false",
+		topologyOverride: rayCluster.Annotations[tpuTopologyOverrideAnnotation],
+	}
+	for _, spec := range rayCluster.Spec.WorkerGroupSpecs {
+		if spec.GroupName != groupName {
+			continue
+		}
+		if spec.NumOfHosts > 0 {
+			cfg.numOfHosts = int(spec.NumOfHosts)
+		}
+		break
+	}
+	return cfg, nil
+}
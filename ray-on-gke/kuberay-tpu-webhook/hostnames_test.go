@@ -0,0 +1,26 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReplicaWorkerHostname(t *testing.T) {
+	got := replicaWorkerHostname("raycluster", "workergroup", 1, 2)
+	want := "raycluster-workergroup-1-2"
+	if got != want {
+		t.Errorf("replicaWorkerHostname() = %q, want %q", got, want)
+	}
+}
+
+func TestWorkerHostnames(t *testing.T) {
+	got := workerHostnames("raycluster", "workergroup", 1, 3)
+	want := []string{
+		"raycluster-workergroup-1-0.raycluster-headless",
+		"raycluster-workergroup-1-1.raycluster-headless",
+		"raycluster-workergroup-1-2.raycluster-headless",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("workerHostnames() = %v, want %v", got, want)
+	}
+}
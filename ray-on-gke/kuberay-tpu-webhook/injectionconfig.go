@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// InjectionContext is the data exposed to an injection config template when rendering
+// it for a single TPU worker pod
+type InjectionContext struct {
+	WorkerID  int
+	Hostnames []string
+	NodePool  string
+	Topology  string
+	// Name identifies this pod's multi-host TPU slice (one replica of a worker group),
+	// shared by every host in Hostnames, for use as TPU_NAME
+	Name string
+}
+
+// InjectionSpec is the set of PodSpec fragments an injection config template can
+// contribute: env vars and volume mounts are appended to every container the pod already
+// has (not to the injected sidecars), Volumes and Containers (sidecars) are appended to
+// the pod spec itself.
+type InjectionSpec struct {
+	Env          []corev1.EnvVar      `json:"env,omitempty"`
+	Volumes      []corev1.Volume      `json:"volumes,omitempty"`
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+	Containers   []corev1.Container   `json:"containers,omitempty"`
+}
+
+var injectionTemplateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// InjectionConfig loads a Go-template injection config from disk and hot-reloads it on
+// change, the way the Istio sidecar injector reloads its ConfigMap-mounted template.
+type InjectionConfig struct {
+	path string
+	log  zerolog.Logger
+
+	mu  sync.RWMutex
+	tpl *template.Template
+}
+
+// LoadInjectionConfig parses the injection config template at path
+func LoadInjectionConfig(path string, log zerolog.Logger) (*InjectionConfig, error) {
+	cfg := &InjectionConfig{path: path, log: log}
+	if err := cfg.reload(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *InjectionConfig) reload() error {
+	raw, err := os.ReadFile(c.path)
+	if err != nil {
+		return fmt.Errorf("reading injection config %s: %w", c.path, err)
+	}
+	tpl, err := template.New(filepath.Base(c.path)).Funcs(injectionTemplateFuncs).Parse(string(raw))
+	if err != nil {
+		return fmt.Errorf("parsing injection config %s: %w", c.path, err)
+	}
+
+	c.mu.Lock()
+	c.tpl = tpl
+	c.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the injection config whenever its file changes on disk, e.g. when the
+// mounted ConfigMap is updated. Runs until ctx is cancelled.
+func (c *InjectionConfig) Watch(ctx context.Context) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		c.log.Error().Err(err).Msg("Failed to start injection config watcher")
+		return
+	}
+	defer watcher.Close()
+
+	// watch the containing directory rather than the file itself: ConfigMap volume
+	// mounts update by atomically swapping a symlinked directory, which doesn't emit
+	// events on the file's own inode
+	if err := watcher.Add(filepath.Dir(c.path)); err != nil {
+		c.log.Error().Err(err).Msg("Failed to watch injection config directory")
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			// don't filter by event name or op: a ConfigMap volume rotation swaps the
+			// whole "..data" symlink target, which surfaces as an event on that symlink
+			// or the directory itself, never on the config file's own dentry
+			if err := c.reload(); err != nil {
+				c.log.Error().Err(err).Msg("Failed to reload injection config")
+				continue
+			}
+			c.log.Info().Str("path", c.path).Msg("Reloaded injection config")
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			c.log.Error().Err(err).Msg("Injection config watcher error")
+		}
+	}
+}
+
+// Render evaluates the injection config template against tplCtx and parses the
+// resulting YAML document into an InjectionSpec
+func (c *InjectionConfig) Render(tplCtx InjectionContext) (*InjectionSpec, error) {
+	c.mu.RLock()
+	tpl := c.tpl
+	c.mu.RUnlock()
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, tplCtx); err != nil {
+		return nil, fmt.Errorf("executing injection config template: %w", err)
+	}
+
+	spec := InjectionSpec{}
+	if err := yaml.Unmarshal(buf.Bytes(), &spec); err != nil {
+		return nil, fmt.Errorf("unmarshalling rendered injection config: %w", err)
+	}
+	return &spec, nil
+}
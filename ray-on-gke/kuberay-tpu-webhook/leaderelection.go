@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const leaseName = "kuberay-tpu-webhook-leader"
+
+// runLeaderElection contends for a Lease in namespace using identity and keeps leading
+// set to whether this replica currently holds leadership. Only the leader is allowed to
+// mutate worker ID state; the other replicas stay up to keep serving `/inject` requests
+// that don't require a write (e.g. RayCluster mutation) while they wait their turn.
+func runLeaderElection(ctx context.Context, clientset kubernetes.Interface, namespace, identity string, leading *atomic.Bool, log zerolog.Logger, onStartedLeading func(context.Context)) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      leaseName,
+			Namespace: namespace,
+		},
+		Client: clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Info().Str("identity", identity).Msg("became leader")
+				leading.Store(true)
+				if err := setLeaderLabel(ctx, clientset, namespace, identity, true); err != nil {
+					log.Error().Err(err).Msg("Failed to label this replica as the leader")
+				}
+				onStartedLeading(ctx)
+			},
+			OnStoppedLeading: func() {
+				log.Info().Str("identity", identity).Msg("stopped leading")
+				leading.Store(false)
+				if err := setLeaderLabel(context.Background(), clientset, namespace, identity, false); err != nil {
+					log.Error().Err(err).Msg("Failed to remove leader label from this replica")
+				}
+			},
+			OnNewLeader: func(currentIdentity string) {
+				if currentIdentity != identity {
+					log.Info().Str("identity", currentIdentity).Msg("observed new leader")
+				}
+			},
+		},
+	})
+}
+
+// setLeaderLabel adds or removes leaderPodLabel on the Pod named podName, so
+// leaderServiceName (a Service selecting that label) always routes to whichever
+// replica currently holds the leader Lease.
+func setLeaderLabel(ctx context.Context, clientset kubernetes.Interface, namespace, podName string, isLeader bool) error {
+	labelValue := `"true"`
+	if !isLeader {
+		labelValue = "null"
+	}
+	patch := []byte(fmt.Sprintf(`{"metadata":{"labels":{%q:%s}}}`, leaderPodLabel, labelValue))
+
+	_, err := clientset.CoreV1().Pods(namespace).Patch(ctx, podName, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("patching leader label onto pod %s/%s: %w", namespace, podName, err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+// leaderPodLabel marks whichever replica currently holds the leader Lease (kept in sync
+// by runLeaderElection), so leaderServiceName always routes to the current leader
+// regardless of which Pod that is.
+const leaderPodLabel = "ray.io/tpu-webhook-leader"
+
+// leaderServiceName is the ClusterIP Service a non-leader replica proxies Pod
+// mutate/delete admission requests to, since only the leader is allowed to
+// assign/release TPU_WORKER_IDs.
+const leaderServiceName = "kuberay-tpu-webhook-leader"
+
+// leaderProxyClient skips verifying the leader's TLS cert: this traffic never leaves the
+// cluster network between replicas of the same webhook, and the real trust boundary is
+// the apiserver-to-webhook hop, not mTLS between replicas.
+var leaderProxyClient = &http.Client{
+	Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+}
+
+// ensureLeaderService creates the ClusterIP Service selecting leaderPodLabel if it
+// doesn't already exist. Safe to call repeatedly.
+func ensureLeaderService(clientset kubernetes.Interface, namespace string) error {
+	_, err := clientset.CoreV1().Services(namespace).Get(context.Background(), leaderServiceName, metav1.GetOptions{})
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      leaderServiceName,
+			Namespace: namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{leaderPodLabel: "true"},
+			Ports: []corev1.ServicePort{
+				{Port: 443, TargetPort: intstr.FromInt(443)},
+			},
+		},
+	}
+	_, err = clientset.CoreV1().Services(namespace).Create(context.Background(), svc, metav1.CreateOptions{})
+	return err
+}
+
+// proxyToLeader forwards a Pod admission request this replica isn't allowed to serve
+// itself to whichever replica currently holds the leader Lease, and copies its response
+// back onto w, so a non-leader replica behind the Service fails closed only if the
+// leader itself is unreachable rather than on every request it happens to receive.
+func proxyToLeader(w http.ResponseWriter, path, namespace string, body []byte) error {
+	url := fmt.Sprintf("https://%s.%s.svc:443%s", leaderServiceName, namespace, path)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("building request to leader: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := leaderProxyClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("proxying to leader: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading leader response: %w", err)
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, err = w.Write(respBody)
+	return err
+}
@@ -1,96 +1,105 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
-	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/rs/zerolog"
-	ray "github.com/ray-project/kuberay/ray-operator/apis/ray/v1alpha1"
+	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+	rayv1alpha1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1alpha1"
+	rayclient "github.com/ray-project/kuberay/ray-operator/pkg/client/clientset/versioned"
 
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
-// represents TPU worker pod
-// for multi slice need to track node pools with pods
-// node pool -> pod slice
-type Pod struct {
-    nodePoolName string
-    podName string
-}
+// store holds the (leader-elected, persisted) TPU_WORKER_ID assignments; set in main
+// before the server starts handling requests
+var store *WorkerIDStore
+
+// k8sClient is used for side effects outside the object under admission review, e.g.
+// provisioning the headless Service multi-host TPU worker pods rely on for DNS
+var k8sClient kubernetes.Interface
 
-// mapping from pods in a slice to unique TPU_WORKER_ID
-var podToId map[Pod]int
+// leading reports whether this replica currently holds the webhook's leader Lease.
+// Only the leader is allowed to assign or release TPU_WORKER_IDs.
+var leading atomic.Bool
 
-// map of node pool names to # of workers created in the slice
-var sliceToWorkers map[string]int
+// injectionConfig renders the env vars, volumes, volumeMounts and sidecar containers
+// mutatePod injects into each TPU worker pod; set in main before the server starts
+var injectionConfig *InjectionConfig
 
-// unmarshal raycluster from admission request
-func extractRayCluster(admissionReview *admissionv1.AdmissionReview) (*ray.RayCluster, error) {
-	if admissionReview.Request.Kind.Kind != "RayCluster" {
-		return nil, fmt.Errorf("Expected RayCluster but got %s", admissionReview.Request.Kind.Kind)
+// unmarshal raycluster from admission request. Accepts both the stable ray.io/v1
+// RayCluster and the deprecated ray.io/v1alpha1 one during the migration window,
+// translating the latter into rayv1 so the rest of the webhook only deals with one
+// API shape.
+func extractRayCluster(admissionReview *admissionv1.AdmissionReview) (*rayv1.RayCluster, error) {
+	kind := admissionReview.Request.Kind
+	if kind.Kind != "RayCluster" {
+		return nil, fmt.Errorf("Expected RayCluster but got %s", kind.Kind)
 	}
 
-	rayCluster := ray.RayCluster{}
+	if kind.Version == "v1alpha1" {
+		legacy := rayv1alpha1.RayCluster{}
+		if err := json.Unmarshal(admissionReview.Request.Object.Raw, &legacy); err != nil {
+			return nil, err
+		}
+		return convertV1alpha1RayCluster(&legacy)
+	}
+
+	rayCluster := rayv1.RayCluster{}
 	if err := json.Unmarshal(admissionReview.Request.Object.Raw, &rayCluster); err != nil {
 		return nil, err
 	}
+	return &rayCluster, nil
+}
 
+// convertV1alpha1RayCluster translates a ray.io/v1alpha1 RayCluster into the stable
+// ray.io/v1 shape. The two APIs are structurally identical field-for-field, so a
+// marshal/unmarshal round trip is sufficient and avoids hand-maintaining a field-by-field
+// copy that would need updating every time either API evolves.
+func convertV1alpha1RayCluster(legacy *rayv1alpha1.RayCluster) (*rayv1.RayCluster, error) {
+	raw, err := json.Marshal(legacy)
+	if err != nil {
+		return nil, fmt.Errorf("converting v1alpha1 RayCluster to v1: %w", err)
+	}
+	rayCluster := rayv1.RayCluster{}
+	if err := json.Unmarshal(raw, &rayCluster); err != nil {
+		return nil, fmt.Errorf("converting v1alpha1 RayCluster to v1: %w", err)
+	}
 	return &rayCluster, nil
 }
 
-// // add TPU_WORKER_HOSTNAMES to containers in a ray cluster
+// ensure the RayCluster's TPU worker pods have a headless Service to resolve their
+// TPU_WORKER_HOSTNAMES against; per-pod hostname/TPU envs are injected later, in
+// mutatePod, once each pod's replica/host index within its worker group is known
 func mutateRayCluster(
 	admissionReview *admissionv1.AdmissionReview,
 ) (*admissionv1.AdmissionResponse, error) {
-	raycluster, _ := extractRayCluster(admissionReview)
-	patches := []map[string]interface{}{}
-
-	for i := 0; i < len(raycluster.Spec.WorkerGroupSpecs); i++ {
-		template := raycluster.Spec.WorkerGroupSpecs[i]
-		numWorkers := template.Replicas
-		
-		hostNames := make([]string, *numWorkers)
-		for j := 0; j < int(*numWorkers); j++ {
-			hostNames[i] = fmt.Sprintf("worker-%d", j)
-		}
-		joinedHostNames := strings.Join(hostNames, ",")
-
-		for j := 0; j < len(raycluster.Spec.WorkerGroupSpecs[i].Template.Spec.Containers); j++ {
-			patch := map[string]interface{}{
-				"op": "add",
-			}
-			container := raycluster.Spec.WorkerGroupSpecs[i].Template.Spec.Containers[j]
-			path := fmt.Sprintf("/spec/workergroupspecs/%d/template/spec/containers/%d/env", i, j)
-			value := corev1.EnvVar{
-				Name:  "TPU_WORKER_HOSTNAMES",
-				Value: joinedHostNames,
-			}
+	raycluster, err := extractRayCluster(admissionReview)
+	if err != nil {
+		return nil, err
+	}
 
-			if len(container.Env) == 0 {
-				patch["path"] = path
-				patch["value"] = []corev1.EnvVar{value}
-			} else {
-				patch["path"] = fmt.Sprintf("%s/-", path)
-				patch["value"] = value
-			}
-			patches = append(patches, patch)
-		}
+	if err := ensureHeadlessService(k8sClient, admissionReview.Request.Namespace, raycluster.Name); err != nil {
+		return nil, fmt.Errorf("ensuring headless service for RayCluster %s: %w", raycluster.Name, err)
 	}
-	patchBytes, _ := json.Marshal(patches)
 
- 	// Create AdmissionResponse
 	admissionResponse := &admissionv1.AdmissionResponse{
-		UID: 	 admissionReview.Request.UID,
+		UID:     admissionReview.Request.UID,
 		Allowed: true,
-		Patch:   patchBytes,
-		PatchType: func() *admissionv1.PatchType {
-			pt := admissionv1.PatchTypeJSONPatch
-			return &pt
-		}(),
 	}
 	return admissionResponse, nil
 }
@@ -109,47 +118,76 @@ func extractPod(admissionReview *admissionv1.AdmissionReview) (*corev1.Pod, erro
 	return &pod, nil
 }
 
-// add TPU_WORKER_ID to pod environment
+// inject TPU context into a worker pod per the configured injection template
 func mutatePod(
 	admissionReview *admissionv1.AdmissionReview,
 ) (*admissionv1.AdmissionResponse, error) {
-	pod, _ := extractPod(admissionReview)
+	if !leading.Load() {
+		return nil, fmt.Errorf("this replica is not currently the webhook leader, retry")
+	}
+
+	pod, err := extractPod(admissionReview)
+	if err != nil {
+		return nil, err
+	}
 	nodePoolName := pod.Labels["cloud.google.com/gke-nodepool"]
-	key := Pod{pod.GenerateName, nodePoolName}	// ray operator only sets GenerateName field
-
-	// assign to the next unique ID in the pod slice
-	tpu_worker_id := sliceToWorkers[nodePoolName]
-	if(podToId[key] > 0) {
-		tpu_worker_id = podToId[key] // if pod has already been assigned - reuse id
-	} else {
-		sliceToWorkers[nodePoolName] += 1
-	}
-	podToId[key] = tpu_worker_id
-
-	// create patch to tell pod how to modify environment
-	patches := []map[string]interface{}{}
-
-	// inject the TPU_WORKER_ID environment variable into each container
-	for i := 0; i < len(pod.Spec.Containers); i++ {
-		path := fmt.Sprintf("/spec/containers/%d/env", i)	// this path must match your pod config
-		value := corev1.EnvVar{
-			Name:  "TPU_WORKER_ID",
-			Value: fmt.Sprint(tpu_worker_id),
-		}
-		patch := map[string]interface{}{
-			"op": "add",
-		}
-		if(len(pod.Spec.Containers[i].Env) == 0) {
-			patch["path"] = path
-			patch["value"] = []corev1.EnvVar{value}
-		} else {
-			patch["path"] = fmt.Sprintf("%s/-", path)
-			patch["value"] = value
-		}
-		patches = append(patches, patch)
+	rayClusterName := pod.Labels[rayClusterNameLabel]
+	groupName := pod.Labels[rayGroupNameLabel]
+	key := Pod{nodePoolName, pod.GenerateName} // ray operator only sets GenerateName field
+
+	groupConfig, err := getWorkerGroupConfig(store.rayClient, admissionReview.Request.Namespace, rayClusterName, groupName)
+	if err != nil {
+		return nil, fmt.Errorf("looking up worker group config for %s: %w", groupName, err)
+	}
+	if !groupConfig.injectionEnabled {
+		return &admissionv1.AdmissionResponse{UID: admissionReview.Request.UID, Allowed: true}, nil
+	}
+
+	// assign to the next unique ID in the pod slice, persisting the assignment onto
+	// the owning RayCluster so a restarted leader can rebuild this state
+	sliceWorkerId, err := store.Assign(admissionReview.Request.Namespace, rayClusterName, nodePoolName, key)
+	if err != nil {
+		return nil, fmt.Errorf("assigning TPU_WORKER_ID: %w", err)
 	}
 
-	patchBytes, _ := json.Marshal(patches)
+	// the pod slice ID is a flat index across every host of every replica in this
+	// node pool; decompose it into which replica and which host within it this pod is
+	replicaIndex := sliceWorkerId / groupConfig.numOfHosts
+	hostIndex := sliceWorkerId % groupConfig.numOfHosts
+	hostnames := workerHostnames(rayClusterName, groupName, replicaIndex, groupConfig.numOfHosts)
+
+	topology := pod.Spec.NodeSelector[tpuTopologyLabel]
+	if groupConfig.topologyOverride != "" {
+		topology = groupConfig.topologyOverride
+	}
+
+	injected, err := injectionConfig.Render(InjectionContext{
+		WorkerID:  hostIndex,
+		Hostnames: hostnames,
+		NodePool:  nodePoolName,
+		Topology:  topology,
+		Name:      fmt.Sprintf("%s-%s-%d", rayClusterName, groupName, replicaIndex),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("rendering injection config: %w", err)
+	}
+
+	// give the pod a deterministic, resolvable DNS name on the headless Service so its
+	// peers can reach it via its TPU_WORKER_HOSTNAMES entry
+	modified := pod.DeepCopy()
+	modified.Spec.Hostname = replicaWorkerHostname(rayClusterName, groupName, replicaIndex, hostIndex)
+	modified.Spec.Subdomain = headlessServiceName(rayClusterName)
+	modified.Spec.Volumes = append(modified.Spec.Volumes, injected.Volumes...)
+	modified.Spec.Containers = append(modified.Spec.Containers, injected.Containers...)
+	for i := range pod.Spec.Containers {
+		modified.Spec.Containers[i].Env = append(modified.Spec.Containers[i].Env, injected.Env...)
+		modified.Spec.Containers[i].VolumeMounts = append(modified.Spec.Containers[i].VolumeMounts, injected.VolumeMounts...)
+	}
+
+	patchBytes, err := diffPodPatch(pod, modified)
+	if err != nil {
+		return nil, fmt.Errorf("computing pod patch: %w", err)
+	}
 
 	admissionResponse := &admissionv1.AdmissionResponse{
 		UID: 	 admissionReview.Request.UID,
@@ -163,41 +201,225 @@ func mutatePod(
 	return admissionResponse, nil
 }
 
-func init() {
-	// mapping from pods in a slice to unique TPU_WORKER_ID
-	podToId = make(map[Pod]int)
-	sliceToWorkers = make(map[string]int)
+// free a pod's TPU_WORKER_ID on delete so a rescheduled replacement can reuse it
+func releasePod(
+	admissionReview *admissionv1.AdmissionReview,
+) (*admissionv1.AdmissionResponse, error) {
+	if !leading.Load() {
+		return nil, fmt.Errorf("this replica is not currently the webhook leader, retry")
+	}
+
+	pod := corev1.Pod{}
+	if err := json.Unmarshal(admissionReview.Request.OldObject.Raw, &pod); err != nil {
+		return nil, err
+	}
+	nodePoolName := pod.Labels["cloud.google.com/gke-nodepool"]
+	rayClusterName := pod.Labels[rayClusterNameLabel]
+	key := Pod{nodePoolName, pod.GenerateName}
+
+	if err := store.Release(admissionReview.Request.Namespace, rayClusterName, key); err != nil {
+		return nil, fmt.Errorf("releasing TPU_WORKER_ID: %w", err)
+	}
+
+	return &admissionv1.AdmissionResponse{
+		UID:     admissionReview.Request.UID,
+		Allowed: true,
+	}, nil
+}
+
+// deniedResponse builds an AdmissionResponse rejecting the request, used when a review
+// can't even be decoded/processed so it must not silently fall through as Allowed:true
+func deniedResponse(uid types.UID, err error) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		UID:     uid,
+		Allowed: false,
+		Result:  &metav1.Status{Message: err.Error()},
+	}
+}
+
+// listRayClusterNames returns the namespace/name of every RayCluster in the cluster,
+// used to rebuild the in-memory worker ID assignments when this replica becomes leader
+func listRayClusterNames(rayClient rayclient.Interface) ([]types.NamespacedName, error) {
+	clusters, err := rayClient.RayV1().RayClusters("").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]types.NamespacedName, 0, len(clusters.Items))
+	for _, c := range clusters.Items {
+		names = append(names, types.NamespacedName{Namespace: c.Namespace, Name: c.Name})
+	}
+	return names, nil
 }
 
 func main() {
 	cert := "/etc/kuberay-tpu-webhook/tls/tls.crt"
 	key := "/etc/kuberay-tpu-webhook/tls/tls.key"
+	injectionConfigPath := flag.String("injection-config", "/etc/kuberay-tpu-webhook/config.yaml", "path to the injection config template")
+	metricsAddr := flag.String("metrics-addr", ":9090", "address to serve Prometheus metrics on")
+	flag.Parse()
+
 	log := zerolog.New(os.Stdout).With().Timestamp().Logger()
 
+	podNamespace := os.Getenv("POD_NAMESPACE")
+	podName := os.Getenv("POD_NAME")
+
+	var err error
+	injectionConfig, err = LoadInjectionConfig(*injectionConfigPath, log)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load injection config")
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load in-cluster config")
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create kubernetes clientset")
+	}
+	k8sClient = clientset
+	rayClient, err := rayclient.NewForConfig(config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create ray clientset")
+	}
+	store = NewWorkerIDStore(rayClient)
+
+	if err := ensureLeaderService(clientset, podNamespace); err != nil {
+		log.Fatal().Err(err).Msg("Failed to ensure leader Service")
+	}
+
+	certWatcher, err := NewCertWatcher(cert, key, log)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load TLS keypair")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go injectionConfig.Watch(ctx)
+	go certWatcher.Watch(ctx)
+	go serveMetrics(*metricsAddr, log)
+	go runLeaderElection(ctx, clientset, podNamespace, podName, &leading, log, func(ctx context.Context) {
+		rayClusters, err := listRayClusterNames(rayClient)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to list RayClusters while assuming leadership")
+			return
+		}
+		for _, rc := range rayClusters {
+			if err := store.LoadRayCluster(rc.Namespace, rc.Name); err != nil {
+				log.Error().Err(err).Str("rayCluster", rc.String()).Msg("Failed to load persisted TPU_WORKER_IDs")
+			}
+		}
+	})
+
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, "kuberay-tpu-webhook")
 	})
 	mux.HandleFunc("/inject", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
+			return
+		}
 		admissionReview := &admissionv1.AdmissionReview{}
-		if err := json.NewDecoder(r.Body).Decode(admissionReview); err != nil {
+		if err := json.Unmarshal(body, admissionReview); err != nil {
 			http.Error(w, "Error decoding request body", http.StatusBadRequest)
 			return
 		}
 
+		if admissionReview.Request.Kind.Kind == "Pod" && !leading.Load() {
+			log.Debug().Msg("Not currently the leader; proxying Pod admission to leader")
+			if err := proxyToLeader(w, r.URL.Path, podNamespace, body); err != nil {
+				log.Error().Err(err).Msg("Failed to proxy Pod admission to leader")
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			}
+			return
+		}
+
 		if admissionReview.Request.Kind.Kind == "RayCluster" {
 			log.Debug().Msg("Received review for RayCluster")
-			admissionReview.Response, _ = mutateRayCluster(admissionReview)
-			responseBytes, _ := json.Marshal(admissionReview)
+			start := time.Now()
+			response, err := mutateRayCluster(admissionReview)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to mutate RayCluster")
+				response = deniedResponse(admissionReview.Request.UID, err)
+			}
+			recordAdmission("RayCluster", start, response, err)
+			admissionReview.Response = response
+			responseBytes, err := json.Marshal(admissionReview)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to marshal admission response")
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
 			fmt.Fprint(w, string(responseBytes))
 			return
 		}
 
 		if admissionReview.Request.Kind.Kind == "Pod" {
+			if admissionReview.Request.Operation == admissionv1.Delete {
+				log.Debug().Msg("Received delete review for Pod")
+				start := time.Now()
+				response, err := releasePod(admissionReview)
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to release TPU_WORKER_ID")
+					response = deniedResponse(admissionReview.Request.UID, err)
+				}
+				recordAdmission("Pod.delete", start, response, err)
+				admissionReview.Response = response
+				responseBytes, err := json.Marshal(admissionReview)
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to marshal admission response")
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				fmt.Fprint(w, string(responseBytes))
+				return
+			}
+
 			log.Debug().Msg("Received review for Pod")
-			admissionReview.Response, _ = mutatePod(admissionReview)
-			responseBytes, _ := json.Marshal(admissionReview)
+			start := time.Now()
+			response, err := mutatePod(admissionReview)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to mutate pod")
+				response = deniedResponse(admissionReview.Request.UID, err)
+			}
+			recordAdmission("Pod", start, response, err)
+			admissionReview.Response = response
+			responseBytes, err := json.Marshal(admissionReview)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to marshal admission response")
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprint(w, string(responseBytes))
+			return
+		}
+	})
+	mux.HandleFunc("/validate", func(w http.ResponseWriter, r *http.Request) {
+		admissionReview := &admissionv1.AdmissionReview{}
+		if err := json.NewDecoder(r.Body).Decode(admissionReview); err != nil {
+			http.Error(w, "Error decoding request body", http.StatusBadRequest)
+			return
+		}
+
+		if admissionReview.Request.Kind.Kind == "RayCluster" {
+			log.Debug().Msg("Received validation review for RayCluster")
+			start := time.Now()
+			response, err := validateRayCluster(admissionReview)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to validate RayCluster")
+				response = deniedResponse(admissionReview.Request.UID, err)
+			}
+			recordAdmission("RayCluster.validate", start, response, err)
+			admissionReview.Response = response
+			responseBytes, err := json.Marshal(admissionReview)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to marshal admission response")
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
 			fmt.Fprint(w, string(responseBytes))
 			return
 		}
@@ -206,9 +428,12 @@ func main() {
 	srv := &http.Server{
 		Addr:    ":443",
 		Handler: mux,
+		TLSConfig: &tls.Config{
+			GetCertificate: certWatcher.GetCertificate,
+		},
 	}
 
-	if err := srv.ListenAndServeTLS(cert, key); err != nil {
+	if err := srv.ListenAndServeTLS("", ""); err != nil {
 		if err == http.ErrServerClosed {
 			log.Info().Msg("Server closed")
 			return
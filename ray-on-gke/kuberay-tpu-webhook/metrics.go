@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+var (
+	admissionRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kuberay_tpu_webhook_admission_requests_total",
+		Help: "Number of admission requests handled, by kind and outcome (allowed/denied/errored).",
+	}, []string{"kind", "outcome"})
+
+	admissionRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kuberay_tpu_webhook_admission_request_duration_seconds",
+		Help:    "Latency of admission requests, by kind.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"kind"})
+
+	patchSizeBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kuberay_tpu_webhook_patch_size_bytes",
+		Help:    "Size in bytes of the JSON patch returned for mutating admission requests.",
+		Buckets: prometheus.ExponentialBuckets(16, 2, 10),
+	}, []string{"kind"})
+
+	activeSlices = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "kuberay_tpu_webhook_active_slices",
+		Help: "Number of TPU node pool slices with at least one allocated TPU_WORKER_ID.",
+	})
+
+	allocatedWorkerIDs = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kuberay_tpu_webhook_allocated_worker_ids",
+		Help: "Number of TPU_WORKER_IDs currently allocated, by node pool.",
+	}, []string{"node_pool"})
+)
+
+// serveMetrics starts a /metrics endpoint on its own port, separate from the TLS
+// admission server, so it can be scraped without admission TLS client certs.
+func serveMetrics(addr string, log zerolog.Logger) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Info().Str("addr", addr).Msg("Serving metrics")
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Error().Err(err).Msg("Metrics server exited")
+	}
+}
+
+// recordAdmission records outcome, latency and patch-size metrics for one admission
+// request of the given kind
+func recordAdmission(kind string, start time.Time, response *admissionv1.AdmissionResponse, err error) {
+	outcome := "allowed"
+	switch {
+	case err != nil:
+		outcome = "errored"
+	case response != nil && !response.Allowed:
+		outcome = "denied"
+	}
+
+	admissionRequestsTotal.WithLabelValues(kind, outcome).Inc()
+	admissionRequestDuration.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+	if response != nil && len(response.Patch) > 0 {
+		patchSizeBytes.WithLabelValues(kind).Observe(float64(len(response.Patch)))
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+
+	jsonpatch "gomodules.xyz/jsonpatch/v2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// diffPodPatch computes the JSON patch turning original into modified, so mutatePod
+// can apply an arbitrary, template-driven set of PodSpec changes instead of
+// hand-building one "add" op per field
+func diffPodPatch(original, modified *corev1.Pod) ([]byte, error) {
+	originalJSON, err := json.Marshal(original)
+	if err != nil {
+		return nil, err
+	}
+	modifiedJSON, err := json.Marshal(modified)
+	if err != nil {
+		return nil, err
+	}
+
+	ops, err := jsonpatch.CreatePatch(originalJSON, modifiedJSON)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ops)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	rayclient "github.com/ray-project/kuberay/ray-operator/pkg/client/clientset/versioned"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// annotation persisting a RayCluster's TPU_WORKER_ID assignments, keyed by node pool
+// name, so a restarted leader can rebuild podToId/sliceToWorkers from cluster state
+const workerIDsAnnotation = "ray.io/tpu-worker-ids"
+
+// label set by the Ray operator identifying the RayCluster a worker pod belongs to
+const rayClusterNameLabel = "ray.io/cluster-name"
+
+// represents TPU worker pod
+// for multi slice need to track node pools with pods
+// node pool -> pod slice
+type Pod struct {
+	nodePoolName string
+	podName      string
+}
+
+// WorkerIDStore tracks TPU_WORKER_ID assignments for TPU worker pods. Assignments are
+// cached in memory and persisted as an annotation on the pod's owning RayCluster, so a
+// restarted leader can rebuild its state instead of losing it on every restart.
+type WorkerIDStore struct {
+	mu sync.Mutex
+
+	// mapping from pods in a slice to unique TPU_WORKER_ID
+	podToId map[Pod]int
+	// mapping from pods to the RayCluster they belong to, so persist can scope the
+	// workerIDsAnnotation it writes to only that RayCluster's own assignments
+	podToRayCluster map[Pod]string
+	// map of node pool names to # of workers created in the slice
+	sliceToWorkers map[string]int
+
+	rayClient rayclient.Interface
+}
+
+func NewWorkerIDStore(rayClient rayclient.Interface) *WorkerIDStore {
+	return &WorkerIDStore{
+		podToId:         make(map[Pod]int),
+		podToRayCluster: make(map[Pod]string),
+		sliceToWorkers:  make(map[string]int),
+		rayClient:       rayClient,
+	}
+}
+
+// LoadRayCluster rebuilds the in-memory assignments for a single RayCluster from its
+// workerIDsAnnotation. Called for each RayCluster the leader discovers on startup.
+func (s *WorkerIDStore) LoadRayCluster(namespace, name string) error {
+	rayCluster, err := s.rayClient.RayV1().RayClusters(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("getting RayCluster %s/%s: %w", namespace, name, err)
+	}
+
+	raw, ok := rayCluster.Annotations[workerIDsAnnotation]
+	if !ok {
+		return nil
+	}
+
+	assignments := map[string][]string{}
+	if err := json.Unmarshal([]byte(raw), &assignments); err != nil {
+		return fmt.Errorf("unmarshalling %s annotation on %s/%s: %w", workerIDsAnnotation, namespace, name, err)
+	}
+
+	s.mu.Lock()
+	for nodePoolName, podIds := range assignments {
+		for _, entry := range podIds {
+			podName, idStr, ok := strings.Cut(entry, "=")
+			if !ok {
+				continue
+			}
+			id, err := strconv.Atoi(idStr)
+			if err != nil {
+				continue
+			}
+			podKey := Pod{nodePoolName, podName}
+			s.podToId[podKey] = id
+			s.podToRayCluster[podKey] = name
+			if id+1 > s.sliceToWorkers[nodePoolName] {
+				s.sliceToWorkers[nodePoolName] = id + 1
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	s.refreshMetrics()
+	return nil
+}
+
+// Assign returns the TPU_WORKER_ID for key, allocating and persisting a new one against
+// the next free slot in nodePoolName's slice if key hasn't been assigned yet.
+func (s *WorkerIDStore) Assign(namespace, rayClusterName, nodePoolName string, key Pod) (int, error) {
+	s.mu.Lock()
+	id, ok := s.podToId[key]
+	if !ok {
+		// take the lowest ID in nodePoolName's slice that no pod holds, so a pod
+		// rescheduled after Release gets back the ID its predecessor freed
+		inUse := map[int]bool{}
+		for k, v := range s.podToId {
+			if k.nodePoolName == nodePoolName {
+				inUse[v] = true
+			}
+		}
+		for id = 0; inUse[id]; id++ {
+		}
+		if id+1 > s.sliceToWorkers[nodePoolName] {
+			s.sliceToWorkers[nodePoolName] = id + 1
+		}
+		s.podToId[key] = id
+		s.podToRayCluster[key] = rayClusterName
+	}
+	s.mu.Unlock()
+
+	if ok {
+		return id, nil
+	}
+	return id, s.persist(namespace, rayClusterName)
+}
+
+// Release frees key's TPU_WORKER_ID so a rescheduled pod can reuse it, and removes the
+// assignment from the persisted annotation.
+func (s *WorkerIDStore) Release(namespace, rayClusterName string, key Pod) error {
+	s.mu.Lock()
+	_, ok := s.podToId[key]
+	delete(s.podToId, key)
+	delete(s.podToRayCluster, key)
+	s.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return s.persist(namespace, rayClusterName)
+}
+
+// persist writes the in-memory assignments belonging to rayClusterName back to its
+// workerIDsAnnotation via a JSON merge patch. Other RayClusters' assignments are left
+// out so this annotation only ever holds that one cluster's own pod slices.
+func (s *WorkerIDStore) persist(namespace, rayClusterName string) error {
+	s.mu.Lock()
+	assignments := map[string][]string{}
+	for key, id := range s.podToId {
+		if s.podToRayCluster[key] != rayClusterName {
+			continue
+		}
+		assignments[key.nodePoolName] = append(assignments[key.nodePoolName], fmt.Sprintf("%s=%d", key.podName, id))
+	}
+	s.mu.Unlock()
+
+	raw, err := json.Marshal(assignments)
+	if err != nil {
+		return fmt.Errorf("marshalling %s annotation: %w", workerIDsAnnotation, err)
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				workerIDsAnnotation: string(raw),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("marshalling annotation patch: %w", err)
+	}
+
+	_, err = s.rayClient.RayV1().RayClusters(namespace).Patch(
+		context.Background(), rayClusterName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("patching RayCluster %s/%s: %w", namespace, rayClusterName, err)
+	}
+
+	s.refreshMetrics()
+	return nil
+}
+
+// refreshMetrics recomputes the active-slices and allocated-worker-IDs-per-node-pool
+// gauges from the current in-memory assignments
+func (s *WorkerIDStore) refreshMetrics() {
+	s.mu.Lock()
+	perPool := map[string]int{}
+	for key := range s.podToId {
+		perPool[key.nodePoolName]++
+	}
+	s.mu.Unlock()
+
+	allocatedWorkerIDs.Reset()
+	for pool, count := range perPool {
+		allocatedWorkerIDs.WithLabelValues(pool).Set(float64(count))
+	}
+	activeSlices.Set(float64(len(perPool)))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+	rayfake "github.com/ray-project/kuberay/ray-operator/pkg/client/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newTestStore(namespace string, rayClusterNames ...string) *WorkerIDStore {
+	objs := make([]runtime.Object, 0, len(rayClusterNames))
+	for _, name := range rayClusterNames {
+		objs = append(objs, &rayv1.RayCluster{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		})
+	}
+	return NewWorkerIDStore(rayfake.NewSimpleClientset(objs...))
+}
+
+func TestWorkerIDStoreAssign(t *testing.T) {
+	store := newTestStore("default", "cluster-a")
+
+	id1, err := store.Assign("default", "cluster-a", "pool-a", Pod{"pool-a", "worker-0"})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+	if id1 != 0 {
+		t.Errorf("first assignment = %d, want 0", id1)
+	}
+
+	id2, err := store.Assign("default", "cluster-a", "pool-a", Pod{"pool-a", "worker-1"})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+	if id2 != 1 {
+		t.Errorf("second assignment = %d, want 1", id2)
+	}
+
+	// re-assigning the same pod returns its existing ID rather than allocating a new one
+	again, err := store.Assign("default", "cluster-a", "pool-a", Pod{"pool-a", "worker-0"})
+	if err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+	if again != id1 {
+		t.Errorf("re-assignment = %d, want %d", again, id1)
+	}
+}
+
+func TestWorkerIDStorePersistScopedToOwningRayCluster(t *testing.T) {
+	store := newTestStore("default", "cluster-a", "cluster-b")
+
+	// seed an assignment belonging to a different RayCluster, as LoadRayCluster would
+	// after rebuilding state for every cluster in the install
+	seeded := Pod{"pool-b", "worker-0"}
+	store.podToId[seeded] = 0
+	store.podToRayCluster[seeded] = "cluster-b"
+
+	if _, err := store.Assign("default", "cluster-a", "pool-a", Pod{"pool-a", "worker-0"}); err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	rc, err := store.rayClient.RayV1().RayClusters("default").Get(context.Background(), "cluster-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	raw, ok := rc.Annotations[workerIDsAnnotation]
+	if !ok {
+		t.Fatalf("expected %s annotation to be set on cluster-a", workerIDsAnnotation)
+	}
+	if strings.Contains(raw, "pool-b") {
+		t.Errorf("cluster-a's persisted annotation %q leaked cluster-b's pool-b assignment", raw)
+	}
+}
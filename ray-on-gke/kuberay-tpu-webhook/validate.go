@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	tpuResourceName     = "google.com/tpu"
+	tpuAcceleratorLabel = "cloud.google.com/gke-tpu-accelerator"
+	tpuTopologyLabel    = "cloud.google.com/gke-tpu-topology"
+)
+
+// number of TPU chips attached to a single host, keyed by GKE TPU accelerator type
+var tpuAcceleratorToChipsPerHost = map[string]int64{
+	"tpu-v4-podslice":      4,
+	"tpu-v5-lite-podslice": 4,
+	"tpu-v5p-slice":        4,
+}
+
+// parseTopology parses a GKE TPU topology label (e.g. "2x2x4") into its dimensions
+func parseTopology(topology string) ([]int64, error) {
+	parts := strings.Split(topology, "x")
+	dims := make([]int64, 0, len(parts))
+	for _, p := range parts {
+		d, err := strconv.ParseInt(p, 10, 64)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid topology %q", topology)
+		}
+		dims = append(dims, d)
+	}
+	return dims, nil
+}
+
+// topologyChips returns the total number of TPU chips implied by a topology's dimensions
+func topologyChips(dims []int64) int64 {
+	chips := int64(1)
+	for _, d := range dims {
+		chips *= d
+	}
+	return chips
+}
+
+// requestsTPU reports whether any container in the pod spec requests TPU chips
+func requestsTPU(spec *corev1.PodSpec) bool {
+	for _, c := range spec.Containers {
+		if _, ok := c.Resources.Requests[tpuResourceName]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validateWorkerGroup checks a single TPU WorkerGroupSpec against its target topology
+func validateWorkerGroup(spec *rayv1.WorkerGroupSpec) error {
+	podSpec := spec.Template.Spec
+	if !requestsTPU(&podSpec) {
+		return nil
+	}
+
+	nodeSelector := podSpec.NodeSelector
+	acceleratorType, ok := nodeSelector[tpuAcceleratorLabel]
+	if !ok {
+		return fmt.Errorf("workerGroupSpec %s: nodeSelector missing %s", spec.GroupName, tpuAcceleratorLabel)
+	}
+	topology, ok := nodeSelector[tpuTopologyLabel]
+	if !ok {
+		return fmt.Errorf("workerGroupSpec %s: nodeSelector missing %s", spec.GroupName, tpuTopologyLabel)
+	}
+
+	chipsPerHost, ok := tpuAcceleratorToChipsPerHost[acceleratorType]
+	if !ok {
+		return fmt.Errorf("workerGroupSpec %s: unknown TPU accelerator type %q", spec.GroupName, acceleratorType)
+	}
+
+	dims, err := parseTopology(topology)
+	if err != nil {
+		return fmt.Errorf("workerGroupSpec %s: %w", spec.GroupName, err)
+	}
+	wantChips := topologyChips(dims)
+
+	// each replica is an independent slice of the declared topology: NumOfHosts is
+	// hosts-per-replica, not total hosts across all replicas (mutatePod's
+	// replicaIndex := sliceWorkerId / groupConfig.numOfHosts relies on the same thing),
+	// so Replicas plays no part in this check
+	numOfHosts := int64(1)
+	if spec.NumOfHosts > 0 {
+		numOfHosts = int64(spec.NumOfHosts)
+	}
+	if gotChips := numOfHosts * chipsPerHost; gotChips != wantChips {
+		return fmt.Errorf("workerGroupSpec %s: numOfHosts (%d) * chips-per-host (%d) = %d does not match topology %q (%d chips)",
+			spec.GroupName, numOfHosts, chipsPerHost, gotChips, topology, wantChips)
+	}
+
+	for i, c := range podSpec.Containers {
+		requests, hasRequests := c.Resources.Requests[tpuResourceName]
+		limits, hasLimits := c.Resources.Limits[tpuResourceName]
+		if !hasRequests || !hasLimits {
+			return fmt.Errorf("workerGroupSpec %s: container %d must set TPU requests and limits", spec.GroupName, i)
+		}
+		if requests.Cmp(limits) != 0 {
+			return fmt.Errorf("workerGroupSpec %s: container %d TPU requests (%s) must equal limits (%s)",
+				spec.GroupName, i, requests.String(), limits.String())
+		}
+		if requests.Value() != chipsPerHost {
+			return fmt.Errorf("workerGroupSpec %s: container %d TPU requests (%s) must equal %s chips-per-host (%d)",
+				spec.GroupName, i, requests.String(), acceleratorType, chipsPerHost)
+		}
+	}
+
+	return nil
+}
+
+// validateRayCluster rejects RayCluster submissions whose TPU WorkerGroupSpecs are
+// inconsistent with their target TPU topology
+func validateRayCluster(admissionReview *admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+	raycluster, err := extractRayCluster(admissionReview)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range raycluster.Spec.WorkerGroupSpecs {
+		if err := validateWorkerGroup(&raycluster.Spec.WorkerGroupSpecs[i]); err != nil {
+			return &admissionv1.AdmissionResponse{
+				UID:     admissionReview.Request.UID,
+				Allowed: false,
+				Result: &metav1.Status{
+					Message: err.Error(),
+				},
+			}, nil
+		}
+	}
+
+	return &admissionv1.AdmissionResponse{
+		UID:     admissionReview.Request.UID,
+		Allowed: true,
+	}, nil
+}
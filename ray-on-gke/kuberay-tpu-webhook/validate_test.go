@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	rayv1 "github.com/ray-project/kuberay/ray-operator/apis/ray/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestParseTopology(t *testing.T) {
+	tests := []struct {
+		topology string
+		want     []int64
+		wantErr  bool
+	}{
+		{"2x2x2", []int64{2, 2, 2}, false},
+		{"4", []int64{4}, false},
+		{"2x0x2", nil, true},
+		{"2xA", nil, true},
+		{"", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTopology(tt.topology)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTopology(%q) error = %v, wantErr %v", tt.topology, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseTopology(%q) = %v, want %v", tt.topology, got, tt.want)
+		}
+	}
+}
+
+func TestTopologyChips(t *testing.T) {
+	if got := topologyChips([]int64{2, 2, 4}); got != 16 {
+		t.Errorf("topologyChips([2,2,4]) = %d, want 16", got)
+	}
+}
+
+func tpuContainer(chips int64) corev1.Container {
+	qty := resource.NewQuantity(chips, resource.DecimalSI)
+	return corev1.Container{
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{tpuResourceName: *qty},
+			Limits:   corev1.ResourceList{tpuResourceName: *qty},
+		},
+	}
+}
+
+func workerGroupSpec(replicas int32, numOfHosts int32, topology string) *rayv1.WorkerGroupSpec {
+	return &rayv1.WorkerGroupSpec{
+		GroupName:  "tpu-workers",
+		Replicas:   &replicas,
+		NumOfHosts: numOfHosts,
+		Template: corev1.PodTemplateSpec{
+			Spec: corev1.PodSpec{
+				NodeSelector: map[string]string{
+					tpuAcceleratorLabel: "tpu-v4-podslice",
+					tpuTopologyLabel:    topology,
+				},
+				Containers: []corev1.Container{tpuContainer(4)},
+			},
+		},
+	}
+}
+
+func TestValidateWorkerGroupMultiHost(t *testing.T) {
+	// NumOfHosts is hosts-per-replica: 4 hosts * 4 chips-per-host = 16 chips = 1x4x4,
+	// independent of how many replicas (independent slices) there are
+	for _, replicas := range []int32{1, 2, 5} {
+		spec := workerGroupSpec(replicas, 4, "1x4x4")
+		if err := validateWorkerGroup(spec); err != nil {
+			t.Errorf("validateWorkerGroup() with replicas=%d = %v, want nil for a valid multi-host slice", replicas, err)
+		}
+	}
+}
+
+func TestValidateWorkerGroupTopologyMismatch(t *testing.T) {
+	// 1 host * 4 chips-per-host = 4 chips, but the topology wants 8; Replicas=2 doesn't
+	// make it valid, since NumOfHosts is per-replica
+	spec := workerGroupSpec(2, 1, "2x2x2")
+	if err := validateWorkerGroup(spec); err == nil {
+		t.Error("validateWorkerGroup() = nil, want error for a topology/chip-count mismatch")
+	}
+}